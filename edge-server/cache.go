@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis/v8"
+	lru "github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// Cache tiers reported via the X-Cache-Tier response header.
+const (
+	cacheTierL1 = "L1"
+	cacheTierL2 = "L2"
+)
+
+type CacheItem struct {
+	Content    []byte            `json:"content"`
+	Headers    map[string]string `json:"headers"`
+	StatusCode int               `json:"status_code"`
+	Timestamp  int64             `json:"timestamp"`
+	TTL        int64             `json:"ttl"`
+	// SWR and SIE are the stale-while-revalidate and stale-if-error
+	// windows (in seconds) that follow TTL, taken from the origin's
+	// Cache-Control header. An item is fresh for TTL seconds, servable
+	// stale while revalidating for the following SWR seconds, and
+	// servable as a stale-if-error fallback for the following SIE
+	// seconds after that.
+	SWR int64 `json:"swr"`
+	SIE int64 `json:"sie"`
+}
+
+// hardExpired reports whether the item has outlived its fresh, SWR, and
+// SIE windows combined, meaning it can no longer be served in any form.
+func (item *CacheItem) hardExpired() bool {
+	return time.Now().Unix() > item.Timestamp+item.TTL+item.SWR+item.SIE
+}
+
+// fresh reports whether the item is still within its TTL.
+func (item *CacheItem) fresh() bool {
+	return time.Now().Unix() <= item.Timestamp+item.TTL
+}
+
+// servableStale reports whether the item is past its TTL but still within
+// its stale-while-revalidate window.
+func (item *CacheItem) servableStale() bool {
+	now := time.Now().Unix()
+	return now > item.Timestamp+item.TTL && now <= item.Timestamp+item.TTL+item.SWR
+}
+
+// servableStaleIfError reports whether the item is past its TTL and SWR
+// window but still within its stale-if-error window.
+func (item *CacheItem) servableStaleIfError() bool {
+	now := time.Now().Unix()
+	return now > item.Timestamp+item.TTL+item.SWR && now <= item.Timestamp+item.TTL+item.SWR+item.SIE
+}
+
+// Provider is a cache backend. Implementations are chosen at startup from
+// the CACHE_URL scheme (see NewProviderFromURL) so edges can run against
+// Redis, memcached, a pure in-process LRU, or a chain of those, without
+// handleContent needing to know which one is in play.
+type Provider interface {
+	Get(ctx context.Context, key string) (*CacheItem, error)
+	Set(ctx context.Context, key string, item *CacheItem, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	Close() error
+}
+
+// TieredProvider is implemented by providers that distinguish where a hit
+// came from (currently only MultiProvider). handleContent uses it to
+// populate the X-Cache-Tier header and the L1 hit/miss counters; providers
+// that don't implement it are treated as a single, untiered store.
+type TieredProvider interface {
+	Provider
+	GetTiered(ctx context.Context, key string) (*CacheItem, string, error)
+}
+
+// HealthChecker is implemented by providers that can report their own
+// connectivity for /health.
+type HealthChecker interface {
+	Healthy() bool
+}
+
+// NewProviderFromURL constructs a Provider from a CACHE_URL value:
+//
+//	redis://host:port              - RedisProvider alone, with no in-process L1 tier
+//	memcached://host:port          - MemcachedProvider
+//	memory://?size=10000           - MemoryProvider, a pure in-process LRU
+//	multi://l1=<url>,l2=<url>      - MultiProvider chaining two providers
+func NewProviderFromURL(rawURL string) (Provider, error) {
+	scheme, _, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid CACHE_URL %q: missing scheme", rawURL)
+	}
+
+	switch scheme {
+	case "redis":
+		return NewRedisProvider(rawURL)
+	case "memcached":
+		return NewMemcachedProvider(strings.TrimPrefix(rawURL, "memcached://")), nil
+	case "memory":
+		size := 1000
+		if u, err := url.Parse(rawURL); err == nil {
+			if s := u.Query().Get("size"); s != "" {
+				if v, err := strconv.Atoi(s); err == nil {
+					size = v
+				}
+			}
+		}
+		return NewMemoryProvider(size, 0), nil
+	case "multi":
+		return newMultiProviderFromURL(rawURL)
+	default:
+		return nil, fmt.Errorf("unsupported CACHE_URL scheme %q", scheme)
+	}
+}
+
+func newMultiProviderFromURL(rawURL string) (Provider, error) {
+	body := strings.TrimPrefix(rawURL, "multi://")
+	l1Part, l2Part, ok := strings.Cut(body, ",l2=")
+	if !ok || !strings.HasPrefix(l1Part, "l1=") {
+		return nil, fmt.Errorf("invalid multi CACHE_URL %q: want multi://l1=<url>,l2=<url>", rawURL)
+	}
+
+	l1, err := NewProviderFromURL(strings.TrimPrefix(l1Part, "l1="))
+	if err != nil {
+		return nil, fmt.Errorf("multi l1: %w", err)
+	}
+	l2, err := NewProviderFromURL(l2Part)
+	if err != nil {
+		return nil, fmt.Errorf("multi l2: %w", err)
+	}
+
+	return &MultiProvider{l1: l1, l2: l2}, nil
+}
+
+// RedisProvider is a Provider backed by Redis.
+type RedisProvider struct {
+	client *redis.Client
+}
+
+func NewRedisProvider(redisURL string) (*RedisProvider, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opt)
+	if _, err := client.Ping(context.Background()).Result(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &RedisProvider{client: client}, nil
+}
+
+func (p *RedisProvider) Get(ctx context.Context, key string) (*CacheItem, error) {
+	data, err := p.client.Get(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var item CacheItem
+	if err := json.Unmarshal([]byte(data), &item); err != nil {
+		return nil, err
+	}
+
+	if item.hardExpired() {
+		p.client.Del(ctx, key)
+		return nil, redis.Nil
+	}
+
+	return &item, nil
+}
+
+func (p *RedisProvider) Set(ctx context.Context, key string, item *CacheItem, ttl time.Duration) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return p.client.Set(ctx, key, data, ttl).Err()
+}
+
+func (p *RedisProvider) Delete(ctx context.Context, key string) error {
+	return p.client.Del(ctx, key).Err()
+}
+
+func (p *RedisProvider) Close() error {
+	return p.client.Close()
+}
+
+func (p *RedisProvider) Healthy() bool {
+	_, err := p.client.Ping(context.Background()).Result()
+	return err == nil
+}
+
+// MemoryProvider is a Provider backed by a bounded in-process LRU, with no
+// secondary store - used standalone for dev/small deployments (memory://)
+// and as the L1 tier of MultiProvider.
+type MemoryProvider struct {
+	cache *lru.LRU[string, *CacheItem]
+}
+
+// NewMemoryProvider creates a MemoryProvider holding up to size entries.
+// ttl is the hard per-entry lifetime enforced by the LRU itself (on top of
+// the CacheItem's own TTL/SWR/SIE bookkeeping); pass 0 to rely solely on
+// the latter.
+func NewMemoryProvider(size int, ttl time.Duration) *MemoryProvider {
+	return &MemoryProvider{cache: lru.NewLRU[string, *CacheItem](size, nil, ttl)}
+}
+
+func (p *MemoryProvider) Get(_ context.Context, key string) (*CacheItem, error) {
+	item, ok := p.cache.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	if item.hardExpired() {
+		p.cache.Remove(key)
+		return nil, nil
+	}
+	return item, nil
+}
+
+func (p *MemoryProvider) Set(_ context.Context, key string, item *CacheItem, _ time.Duration) error {
+	p.cache.Add(key, item)
+	return nil
+}
+
+func (p *MemoryProvider) Delete(_ context.Context, key string) error {
+	p.cache.Remove(key)
+	return nil
+}
+
+func (p *MemoryProvider) Close() error {
+	return nil
+}
+
+// MemcachedProvider is a Provider backed by memcached.
+type MemcachedProvider struct {
+	client *memcache.Client
+}
+
+func NewMemcachedProvider(addr string) *MemcachedProvider {
+	return &MemcachedProvider{client: memcache.New(strings.Split(addr, ",")...)}
+}
+
+func (p *MemcachedProvider) Get(_ context.Context, key string) (*CacheItem, error) {
+	entry, err := p.client.Get(key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var item CacheItem
+	if err := json.Unmarshal(entry.Value, &item); err != nil {
+		return nil, err
+	}
+
+	if item.hardExpired() {
+		p.client.Delete(key)
+		return nil, nil
+	}
+
+	return &item, nil
+}
+
+func (p *MemcachedProvider) Set(_ context.Context, key string, item *CacheItem, ttl time.Duration) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return p.client.Set(&memcache.Item{Key: key, Value: data, Expiration: int32(ttl.Seconds())})
+}
+
+func (p *MemcachedProvider) Delete(_ context.Context, key string) error {
+	err := p.client.Delete(key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+func (p *MemcachedProvider) Close() error {
+	return nil
+}
+
+// MultiProvider chains two providers, treating the first as a fast L1
+// cache in front of the second (L2). Reads check L1 first and promote L2
+// hits into it; writes go to L1 and then to L2. An L2 write error is
+// returned, but the copy already written to L1 is kept.
+type MultiProvider struct {
+	l1 Provider
+	l2 Provider
+}
+
+func (m *MultiProvider) Get(ctx context.Context, key string) (*CacheItem, error) {
+	item, _, err := m.GetTiered(ctx, key)
+	return item, err
+}
+
+func (m *MultiProvider) GetTiered(ctx context.Context, key string) (*CacheItem, string, error) {
+	if item, err := m.l1.Get(ctx, key); err == nil && item != nil {
+		return item, cacheTierL1, nil
+	}
+
+	item, err := m.l2.Get(ctx, key)
+	if err != nil || item == nil {
+		return nil, "", err
+	}
+
+	if err := m.l1.Set(ctx, key, item, time.Duration(item.TTL)*time.Second); err != nil {
+		log.Printf("multi cache: failed to promote %s into L1: %v", key, err)
+	}
+	return item, cacheTierL2, nil
+}
+
+func (m *MultiProvider) Set(ctx context.Context, key string, item *CacheItem, ttl time.Duration) error {
+	if err := m.l1.Set(ctx, key, item, ttl); err != nil {
+		log.Printf("multi cache: failed to write %s into L1: %v", key, err)
+	}
+	return m.l2.Set(ctx, key, item, ttl)
+}
+
+func (m *MultiProvider) Delete(ctx context.Context, key string) error {
+	if err := m.l1.Delete(ctx, key); err != nil {
+		log.Printf("multi cache: failed to delete %s from L1: %v", key, err)
+	}
+	return m.l2.Delete(ctx, key)
+}
+
+func (m *MultiProvider) Close() error {
+	if err := m.l1.Close(); err != nil {
+		return err
+	}
+	return m.l2.Close()
+}
+
+func (m *MultiProvider) Healthy() bool {
+	for _, p := range []Provider{m.l1, m.l2} {
+		if hc, ok := p.(HealthChecker); ok && !hc.Healthy() {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheDirectives holds the subset of Cache-Control directives relevant
+// to edge caching.
+type cacheDirectives struct {
+	ttl     int64
+	ttlSet  bool
+	swr     int64
+	sie     int64
+	noStore bool
+}
+
+// parseCacheControl parses a Cache-Control header value. s-maxage takes
+// priority over max-age (as it does for shared caches generally);
+// no-cache pins the TTL to zero so the edge always revalidates. private
+// forces noStore: this edge is a shared cache, and a shared cache must
+// never store a private response, regardless of what other directives
+// (e.g. a same-header max-age=) appear alongside it.
+func parseCacheControl(cacheControl string) cacheDirectives {
+	var d cacheDirectives
+	if cacheControl == "" {
+		return d
+	}
+
+	hasSMaxAge, noCache := false, false
+	for _, part := range strings.Split(cacheControl, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "no-store", part == "private":
+			d.noStore = true
+		case part == "no-cache":
+			noCache = true
+		case strings.HasPrefix(part, "s-maxage="):
+			if v, err := strconv.ParseInt(strings.TrimPrefix(part, "s-maxage="), 10, 64); err == nil {
+				d.ttl, d.ttlSet, hasSMaxAge = v, true, true
+			}
+		case strings.HasPrefix(part, "max-age="):
+			if v, err := strconv.ParseInt(strings.TrimPrefix(part, "max-age="), 10, 64); err == nil && !hasSMaxAge {
+				d.ttl, d.ttlSet = v, true
+			}
+		case strings.HasPrefix(part, "stale-while-revalidate="):
+			if v, err := strconv.ParseInt(strings.TrimPrefix(part, "stale-while-revalidate="), 10, 64); err == nil {
+				d.swr = v
+			}
+		case strings.HasPrefix(part, "stale-if-error="):
+			if v, err := strconv.ParseInt(strings.TrimPrefix(part, "stale-if-error="), 10, 64); err == nil {
+				d.sie = v
+			}
+		}
+	}
+
+	if noCache {
+		d.ttl, d.ttlSet = 0, true
+	}
+
+	return d
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCacheItemWindows checks the boundary semantics of the three
+// adjoining freshness windows (fresh -> SWR -> SIE -> hard expired).
+// Each window after the first is exclusive on its near edge and inclusive
+// on its far edge, e.g. an item is still fresh exactly at TTL seconds old but not a
+// second later.
+func TestCacheItemWindows(t *testing.T) {
+	const ttl, swr, sie = 100, 50, 25
+
+	tests := []struct {
+		name                     string
+		ageSeconds               int64
+		wantFresh                bool
+		wantServableStale        bool
+		wantServableStaleIfError bool
+		wantHardExpired          bool
+	}{
+		{"well within TTL", 0, true, false, false, false},
+		{"exactly at TTL boundary", ttl, true, false, false, false},
+		{"just past TTL", ttl + 1, false, true, false, false},
+		{"within SWR window", ttl + swr/2, false, true, false, false},
+		{"exactly at SWR boundary", ttl + swr, false, true, false, false},
+		{"just past SWR", ttl + swr + 1, false, false, true, false},
+		{"within SIE window", ttl + swr + sie/2, false, false, true, false},
+		{"exactly at SIE boundary", ttl + swr + sie, false, false, true, false},
+		{"just past SIE (hard expired)", ttl + swr + sie + 1, false, false, false, true},
+		{"long hard expired", ttl + swr + sie + 1000, false, false, false, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			item := &CacheItem{
+				Timestamp: time.Now().Unix() - tc.ageSeconds,
+				TTL:       ttl,
+				SWR:       swr,
+				SIE:       sie,
+			}
+
+			if got := item.fresh(); got != tc.wantFresh {
+				t.Errorf("fresh() = %v, want %v", got, tc.wantFresh)
+			}
+			if got := item.servableStale(); got != tc.wantServableStale {
+				t.Errorf("servableStale() = %v, want %v", got, tc.wantServableStale)
+			}
+			if got := item.servableStaleIfError(); got != tc.wantServableStaleIfError {
+				t.Errorf("servableStaleIfError() = %v, want %v", got, tc.wantServableStaleIfError)
+			}
+			if got := item.hardExpired(); got != tc.wantHardExpired {
+				t.Errorf("hardExpired() = %v, want %v", got, tc.wantHardExpired)
+			}
+		})
+	}
+}
+
+// TestCacheItemWindowsZeroSWRAndSIE checks that an item with no SWR/SIE
+// configured (the common case when the origin sends neither directive)
+// goes straight from fresh to hard expired with no stale window at all.
+func TestCacheItemWindowsZeroSWRAndSIE(t *testing.T) {
+	const ttl = 100
+
+	fresh := &CacheItem{Timestamp: time.Now().Unix(), TTL: ttl}
+	if !fresh.fresh() || fresh.servableStale() || fresh.servableStaleIfError() || fresh.hardExpired() {
+		t.Errorf("fresh item got unexpected window state: fresh=%v stale=%v sie=%v expired=%v",
+			fresh.fresh(), fresh.servableStale(), fresh.servableStaleIfError(), fresh.hardExpired())
+	}
+
+	expired := &CacheItem{Timestamp: time.Now().Unix() - ttl - 1, TTL: ttl}
+	if expired.fresh() || expired.servableStale() || expired.servableStaleIfError() || !expired.hardExpired() {
+		t.Errorf("expired item got unexpected window state: fresh=%v stale=%v sie=%v expired=%v",
+			expired.fresh(), expired.servableStale(), expired.servableStaleIfError(), expired.hardExpired())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+)
+
+// negotiateEncoding picks the best content-coding to serve from an
+// Accept-Encoding header, preferring brotli over gzip when both are
+// acceptable with the same weight. It returns "" when the client doesn't
+// accept either (the response, and its cache entry, are then served as
+// identity).
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	preference := map[string]int{"br": 2, "gzip": 1}
+	best, bestQ, bestPreference := "", 0.0, -1
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, q := part, 1.0
+		if i := strings.Index(part, ";q="); i >= 0 {
+			name = strings.TrimSpace(part[:i])
+			if v, err := strconv.ParseFloat(part[i+3:], 64); err == nil {
+				q = v
+			}
+		}
+
+		p, known := preference[name]
+		if !known || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && p > bestPreference) {
+			best, bestQ, bestPreference = name, q, p
+		}
+	}
+
+	return best
+}
+
+// isCompressibleContentType reports whether a response body is worth
+// spending CPU to compress.
+func isCompressibleContentType(contentType string) bool {
+	ct := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	switch {
+	case strings.HasPrefix(ct, "text/"):
+		return true
+	case ct == "application/json",
+		ct == "application/javascript",
+		ct == "application/xml",
+		ct == "image/svg+xml":
+		return true
+	default:
+		return false
+	}
+}
+
+// compressBody compresses body with the given content-coding ("gzip" or
+// "br"). Any other value, including "", returns body unchanged.
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch encoding {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "br":
+		w := brotli.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return body, nil
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2/expirable"
+	geoip2 "github.com/oschwald/geoip2-golang"
+)
+
+// geoCacheSize and geoCacheTTL bound the read-through cache of recent IP
+// lookups so a busy edge doesn't hit the GeoIP database on disk for every
+// request from the same handful of networks.
+const (
+	geoCacheSize = 10000
+	geoCacheTTL  = 10 * time.Minute
+)
+
+// GeoInfo is the subset of a GeoIP2 City/ASN lookup we care about for
+// analytics and routing.
+type GeoInfo struct {
+	Country   string
+	City      string
+	Continent string
+	ASN       string
+}
+
+// GeoIP resolves client IPs to GeoInfo using a MaxMind GeoIP2 database. A
+// nil *GeoIP is valid and every method is a safe no-op, so edges can run
+// with GEOIP_DB unset.
+type GeoIP struct {
+	reader *geoip2.Reader
+	cache  *lru.LRU[string, *GeoInfo]
+}
+
+// NewGeoIP opens the GeoIP2 database at path. An empty path is not an
+// error - it simply means GeoIP enrichment is disabled.
+func NewGeoIP(path string) (*GeoIP, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database %q: %w", path, err)
+	}
+
+	return &GeoIP{
+		reader: reader,
+		cache:  lru.NewLRU[string, *GeoInfo](geoCacheSize, nil, geoCacheTTL),
+	}, nil
+}
+
+// Lookup resolves ip to GeoInfo, serving from the read-through cache when
+// possible.
+func (g *GeoIP) Lookup(ip net.IP) (*GeoInfo, error) {
+	if g == nil || ip == nil {
+		return nil, nil
+	}
+
+	key := ip.String()
+	if info, ok := g.cache.Get(key); ok {
+		return info, nil
+	}
+
+	city, err := g.reader.City(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &GeoInfo{
+		Country:   city.Country.IsoCode,
+		City:      city.City.Names["en"],
+		Continent: city.Continent.Code,
+	}
+
+	// ASN lookups require a GeoLite2-ASN database; GEOIP_DB commonly
+	// points at a City database instead, so a failure here is expected
+	// and left blank rather than treated as a lookup error.
+	if asn, err := g.reader.ASN(ip); err == nil {
+		info.ASN = fmt.Sprintf("AS%d %s", asn.AutonomousSystemNumber, asn.AutonomousSystemOrganization)
+	}
+
+	g.cache.Add(key, info)
+	return info, nil
+}
+
+func (g *GeoIP) Close() error {
+	if g == nil {
+		return nil
+	}
+	return g.reader.Close()
+}
+
+// parseTrustedProxyCIDRs parses a comma-separated list of CIDRs (e.g. from
+// TRUSTED_PROXY_CIDRS). Malformed entries are logged and skipped.
+func parseTrustedProxyCIDRs(raw string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			log.Printf("Ignoring malformed trusted proxy CIDR %q: %v", part, err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// clientIPForGeo returns the IP to use for GeoIP lookups: the left-most
+// (original client) address in X-Forwarded-For when the immediate peer is
+// a trusted proxy, otherwise r.RemoteAddr.
+func clientIPForGeo(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" && remote != nil && isTrustedProxy(remote, trustedProxies) {
+		first := strings.TrimSpace(strings.Split(xff, ",")[0])
+		if ip := net.ParseIP(first); ip != nil {
+			return ip
+		}
+	}
+
+	return remote
+}
+
+// lookupGeo resolves the GeoInfo for r's client, recording GeoIP
+// lookup/error counts and the per-country request counter. It returns nil
+// when GeoIP is disabled or the lookup fails.
+func (es *EdgeServer) lookupGeo(r *http.Request) *GeoInfo {
+	if es.GeoIP == nil {
+		es.Metrics.RequestsByCountry.WithLabelValues("unknown").Inc()
+		return nil
+	}
+
+	es.Metrics.GeoIPLookups.Inc()
+	geo, err := es.GeoIP.Lookup(clientIPForGeo(r, es.trustedProxies))
+	if err != nil || geo == nil {
+		if err != nil {
+			es.Metrics.GeoIPErrors.Inc()
+		}
+		es.Metrics.RequestsByCountry.WithLabelValues("unknown").Inc()
+		return nil
+	}
+
+	country := geo.Country
+	if country == "" {
+		country = "unknown"
+	}
+	es.Metrics.RequestsByCountry.WithLabelValues(country).Inc()
+
+	return geo
+}
+
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
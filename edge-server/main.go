@@ -3,51 +3,76 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"context"
-
-	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/singleflight"
 )
 
 type EdgeServer struct {
-	ID          string
-	Region      string
-	RedisClient *redis.Client
-	OriginURL   string
-	Cache       *Cache
-	Metrics     *Metrics
+	ID        string
+	Region    string
+	OriginURL string
+	Cache     Provider
+	Metrics   *Metrics
+	GeoIP     *GeoIP
+
+	// RateLimiter enforces per-client request limits. Nil disables rate
+	// limiting (the default when RATE_RPS is unset).
+	RateLimiter *RateLimiter
+
+	// trustedProxies are the CIDRs (TRUSTED_PROXY_CIDRS) whose
+	// X-Forwarded-For header is honored when resolving a client IP for
+	// GeoIP lookups.
+	trustedProxies []*net.IPNet
+
+	// originGroup coalesces concurrent origin fetches for the same cache
+	// key into a single in-flight request.
+	originGroup singleflight.Group
+
+	// shutdownCtx is canceled as soon as shutdown begins, so background
+	// work that outlives the triggering request (revalidation) is still
+	// bounded by the server's lifetime.
+	shutdownCtx context.Context
+
+	// shuttingDown flips to true once shutdown begins, so /health can
+	// start returning 503 and let load balancers drain this node before
+	// in-flight requests are forced to finish.
+	shuttingDown atomic.Bool
+
+	// analytics is the bounded worker pool that sends analytics events;
+	// shutdown waits on it so pending POSTs aren't dropped mid-flight.
+	analytics *analyticsPool
 }
 
 type Metrics struct {
-	RequestsTotal    prometheus.Counter
-	CacheHits        prometheus.Counter
-	CacheMisses      prometheus.Counter
-	ResponseDuration prometheus.Histogram
-	OriginRequests   prometheus.Counter
-}
-
-type Cache struct {
-	redis *redis.Client
-	ctx   context.Context
-}
-
-type CacheItem struct {
-	Content    []byte            `json:"content"`
-	Headers    map[string]string `json:"headers"`
-	StatusCode int               `json:"status_code"`
-	Timestamp  int64             `json:"timestamp"`
-	TTL        int64             `json:"ttl"`
+	RequestsTotal     prometheus.Counter
+	CacheHits         prometheus.Counter
+	CacheMisses       prometheus.Counter
+	ResponseDuration  prometheus.Histogram
+	OriginRequests    prometheus.Counter
+	LocalCacheHits    prometheus.Counter
+	LocalCacheMisses  prometheus.Counter
+	OriginCoalesced   prometheus.Counter
+	GeoIPLookups      prometheus.Counter
+	GeoIPErrors       prometheus.Counter
+	RequestsByCountry *prometheus.CounterVec
+	RateLimited       *prometheus.CounterVec
 }
 
 type AnalyticsData struct {
@@ -61,27 +86,20 @@ type AnalyticsData struct {
 	BytesSent    int    `json:"bytes_sent"`
 	ClientIP     string `json:"client_ip"`
 	UserAgent    string `json:"user_agent"`
+	Country      string `json:"country,omitempty"`
+	City         string `json:"city,omitempty"`
+	Continent    string `json:"continent,omitempty"`
+	ASN          string `json:"asn,omitempty"`
 }
 
 func NewEdgeServer() *EdgeServer {
 	edgeID := getEnv("EDGE_ID", "edge-1")
 	region := getEnv("REGION", "us-east-1")
-	redisURL := getEnv("REDIS_URL", "redis://localhost:6379")
 	originURL := getEnv("ORIGIN_URL", "http://localhost:3000")
 
-	// Redis client
-	opt, err := redis.ParseURL(redisURL)
+	cache, err := newCacheProvider()
 	if err != nil {
-		log.Fatal("Failed to parse Redis URL:", err)
-	}
-
-	redisClient := redis.NewClient(opt)
-
-	// Test Redis connection
-	ctx := context.Background()
-	_, err = redisClient.Ping(ctx).Result()
-	if err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+		log.Fatal("Failed to initialize cache provider:", err)
 	}
 
 	// Initialize metrics
@@ -111,6 +129,41 @@ func NewEdgeServer() *EdgeServer {
 			Help:        "Total number of requests to origin server",
 			ConstLabels: prometheus.Labels{"edge_id": edgeID, "region": region},
 		}),
+		LocalCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "edge_local_cache_hits_total",
+			Help:        "Total number of L1 (in-process) cache hits",
+			ConstLabels: prometheus.Labels{"edge_id": edgeID, "region": region},
+		}),
+		LocalCacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "edge_local_cache_misses_total",
+			Help:        "Total number of L1 (in-process) cache misses",
+			ConstLabels: prometheus.Labels{"edge_id": edgeID, "region": region},
+		}),
+		OriginCoalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "edge_origin_coalesced_total",
+			Help:        "Total number of requests that were suppressed and served from an in-flight origin fetch",
+			ConstLabels: prometheus.Labels{"edge_id": edgeID, "region": region},
+		}),
+		GeoIPLookups: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "geoip_lookups_total",
+			Help:        "Total number of GeoIP lookups performed",
+			ConstLabels: prometheus.Labels{"edge_id": edgeID, "region": region},
+		}),
+		GeoIPErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "geoip_lookup_errors_total",
+			Help:        "Total number of GeoIP lookups that failed",
+			ConstLabels: prometheus.Labels{"edge_id": edgeID, "region": region},
+		}),
+		RequestsByCountry: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "edge_requests_by_country_total",
+			Help:        "Total number of requests seen per resolved client country",
+			ConstLabels: prometheus.Labels{"edge_id": edgeID, "region": region},
+		}, []string{"country"}),
+		RateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "edge_rate_limited_total",
+			Help:        "Total number of requests rejected by the rate limiter",
+			ConstLabels: prometheus.Labels{"edge_id": edgeID, "region": region},
+		}, []string{"tier"}),
 	}
 
 	// Register metrics
@@ -119,24 +172,93 @@ func NewEdgeServer() *EdgeServer {
 	prometheus.MustRegister(metrics.CacheMisses)
 	prometheus.MustRegister(metrics.ResponseDuration)
 	prometheus.MustRegister(metrics.OriginRequests)
+	prometheus.MustRegister(metrics.LocalCacheHits)
+	prometheus.MustRegister(metrics.LocalCacheMisses)
+	prometheus.MustRegister(metrics.OriginCoalesced)
+	prometheus.MustRegister(metrics.GeoIPLookups)
+	prometheus.MustRegister(metrics.GeoIPErrors)
+	prometheus.MustRegister(metrics.RequestsByCountry)
+	prometheus.MustRegister(metrics.RateLimited)
+
+	geoIP, err := NewGeoIP(getEnv("GEOIP_DB", ""))
+	if err != nil {
+		log.Fatal("Failed to initialize GeoIP:", err)
+	}
 
-	cache := &Cache{
-		redis: redisClient,
-		ctx:   ctx,
+	rateLimiter, err := newRateLimiter()
+	if err != nil {
+		log.Fatal("Failed to initialize rate limiter:", err)
 	}
 
 	return &EdgeServer{
-		ID:          edgeID,
-		Region:      region,
-		RedisClient: redisClient,
-		OriginURL:   originURL,
-		Cache:       cache,
-		Metrics:     metrics,
+		ID:             edgeID,
+		Region:         region,
+		OriginURL:      originURL,
+		Cache:          cache,
+		Metrics:        metrics,
+		GeoIP:          geoIP,
+		RateLimiter:    rateLimiter,
+		trustedProxies: parseTrustedProxyCIDRs(getEnv("TRUSTED_PROXY_CIDRS", "")),
+		shutdownCtx:    context.Background(),
+		analytics:      newAnalyticsPool(getEnvInt("ANALYTICS_WORKERS", 4)),
 	}
 }
 
-// Analytics tracking function
-func (es *EdgeServer) trackAnalytics(r *http.Request, cacheStatus string, responseTime time.Duration, bytesSent int) {
+// newRateLimiter builds the RateLimiter selected by RATE_RPS/RATE_BURST.
+// Rate limiting is disabled (nil, nil) when RATE_RPS is unset, so existing
+// deployments keep running unthrottled unless they opt in. The global
+// (fleet-wide) tier has its own RATE_GLOBAL_RPS/RATE_GLOBAL_BURST, since
+// the aggregate limit across every edge must scale independently of any
+// single edge's local burst.
+func newRateLimiter() (*RateLimiter, error) {
+	rpsRaw := getEnv("RATE_RPS", "")
+	if rpsRaw == "" {
+		return nil, nil
+	}
+
+	rps, err := strconv.ParseFloat(rpsRaw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_RPS %q: %w", rpsRaw, err)
+	}
+	burst := getEnvInt("RATE_BURST", int(rps))
+
+	globalRPSRaw := getEnv("RATE_GLOBAL_RPS", "")
+	globalRPS := rps
+	if globalRPSRaw != "" {
+		globalRPS, err = strconv.ParseFloat(globalRPSRaw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_GLOBAL_RPS %q: %w", globalRPSRaw, err)
+		}
+	}
+	globalBurst := getEnvInt("RATE_GLOBAL_BURST", int(globalRPS))
+
+	return NewRateLimiter(rps, burst, globalBurst, getEnv("RATE_LIMIT_REDIS_URL", ""))
+}
+
+// newCacheProvider builds the Provider selected by CACHE_URL. When unset,
+// it reconstructs the historical default: an in-process LRU (sized by
+// EDGE_LOCAL_CACHE_SIZE/EDGE_LOCAL_TTL_MAX) in front of Redis
+// (REDIS_URL), so existing deployments don't need to set CACHE_URL to
+// keep their current behavior.
+func newCacheProvider() (Provider, error) {
+	if cacheURL := getEnv("CACHE_URL", ""); cacheURL != "" {
+		return NewProviderFromURL(cacheURL)
+	}
+
+	redisProvider, err := NewRedisProvider(getEnv("REDIS_URL", "redis://localhost:6379"))
+	if err != nil {
+		return nil, err
+	}
+
+	localSize := getEnvInt("EDGE_LOCAL_CACHE_SIZE", 1000)
+	localTTLMax := time.Duration(getEnvInt("EDGE_LOCAL_TTL_MAX", 60)) * time.Second
+
+	return &MultiProvider{l1: NewMemoryProvider(localSize, localTTLMax), l2: redisProvider}, nil
+}
+
+// Analytics tracking function. geo may be nil when GeoIP isn't configured
+// or the lookup failed.
+func (es *EdgeServer) trackAnalytics(r *http.Request, cacheStatus string, responseTime time.Duration, bytesSent int, geo *GeoInfo) {
 	analyticsData := AnalyticsData{
 		Timestamp:    time.Now().Unix(),
 		Method:       r.Method,
@@ -149,9 +271,16 @@ func (es *EdgeServer) trackAnalytics(r *http.Request, cacheStatus string, respon
 		ClientIP:     r.RemoteAddr,
 		UserAgent:    r.Header.Get("User-Agent"),
 	}
+	if geo != nil {
+		analyticsData.Country = geo.Country
+		analyticsData.City = geo.City
+		analyticsData.Continent = geo.Continent
+		analyticsData.ASN = geo.ASN
+	}
 
-	// Send to analytics service asynchronously
-	go func() {
+	// Send to analytics service on the bounded worker pool so shutdown can
+	// wait for pending events to flush instead of abandoning them.
+	es.analytics.submit(func() {
 		jsonData, err := json.Marshal(analyticsData)
 		if err != nil {
 			log.Printf("Failed to marshal analytics data: %v", err)
@@ -166,7 +295,7 @@ func (es *EdgeServer) trackAnalytics(r *http.Request, cacheStatus string, respon
 		defer resp.Body.Close()
 
 		log.Printf("[%s] Analytics sent: %s - %s", es.ID, cacheStatus, r.URL.Path)
-	}()
+	})
 }
 
 // FIXED: Complete handleContent function with proper cache logic
@@ -178,99 +307,255 @@ func (es *EdgeServer) handleContent(w http.ResponseWriter, r *http.Request) {
 
 	es.Metrics.RequestsTotal.Inc()
 
-	// Generate cache key - clean the path for consistent caching
+	// Generate cache key - clean the path for consistent caching. The
+	// negotiated content-coding is part of the key so a gzip response
+	// never gets served to a client that only accepts identity, and vice
+	// versa.
 	cleanPath := strings.TrimPrefix(r.URL.Path, "/")
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
 	cacheKey := fmt.Sprintf("content:%s:%s", r.Method, cleanPath)
+	if encoding != "" {
+		cacheKey = fmt.Sprintf("%s:%s", cacheKey, encoding)
+	}
 
-	// Try to get from cache first
-	cached, err := es.Cache.Get(cacheKey)
+	geo := es.lookupGeo(r)
+
+	// Try to get from cache first. An entry may come back fresh, stale
+	// (past TTL but within its SWR window), or stale-if-error-only (past
+	// SWR but within SIE) - Cache.Get hands back anything not yet hard
+	// expired and leaves freshness to us. Only a TieredProvider (the
+	// default L1-LRU-in-front-of-L2 setup) reports which tier served the
+	// request; other backends are treated as a single, untiered store.
+	var cached *CacheItem
+	var tier string
+	var err error
+	tp, tiered := es.Cache.(TieredProvider)
+	if tiered {
+		cached, tier, err = tp.GetTiered(r.Context(), cacheKey)
+	} else {
+		cached, err = es.Cache.Get(r.Context(), cacheKey)
+		tier = cacheTierL2
+	}
 	if err == nil && cached != nil {
-		es.Metrics.CacheHits.Inc()
+		if tiered {
+			if tier == cacheTierL1 {
+				es.Metrics.LocalCacheHits.Inc()
+			} else {
+				es.Metrics.LocalCacheMisses.Inc()
+			}
+		}
 
-		// Set headers from cache
-		for key, value := range cached.Headers {
-			w.Header().Set(key, value)
+		if cached.fresh() {
+			es.Metrics.CacheHits.Inc()
+			es.writeCached(w, cached, tier, "HIT")
+			es.trackAnalytics(r, "HIT", time.Since(start), len(cached.Content), geo)
+			log.Printf("[%s] Cache HIT for %s", es.ID, r.URL.Path)
+			return
 		}
-		w.Header().Set("X-Cache", "HIT")
-		w.Header().Set("X-Edge-Server", es.ID)
-		w.Header().Set("X-Edge-Region", es.Region)
-		w.WriteHeader(cached.StatusCode)
-		w.Write(cached.Content)
 
-		// Track cache HIT analytics
-		es.trackAnalytics(r, "HIT", time.Since(start), len(cached.Content))
+		if cached.servableStale() {
+			es.Metrics.CacheHits.Inc()
+			es.writeCached(w, cached, tier, "STALE")
+			es.trackAnalytics(r, "STALE", time.Since(start), len(cached.Content), geo)
+			log.Printf("[%s] Serving STALE for %s, revalidating in background", es.ID, r.URL.Path)
 
-		log.Printf("[%s] Cache HIT for %s", es.ID, r.URL.Path)
-		return
+			go es.revalidate(r.Clone(es.shutdownCtx), cacheKey, encoding)
+			return
+		}
+	} else if tiered {
+		es.Metrics.LocalCacheMisses.Inc()
 	}
 
-	// Cache MISS - fetch from origin
+	// Cache MISS (or stale-if-error-only entry) - fetch from origin,
+	// coalescing concurrent fetches for the same cache key into a single
+	// request to the origin.
 	es.Metrics.CacheMisses.Inc()
 
-	// Fetch from origin server
-	originResp, err := es.fetchFromOrigin(r)
-	if err != nil {
-		log.Printf("[%s] Error fetching from origin: %v", es.ID, err)
+	leader := false
+	v, fetchErr, _ := es.originGroup.Do(cacheKey, func() (interface{}, error) {
+		leader = true
+		return es.fetchAndCacheOrigin(r, cacheKey, encoding)
+	})
+	if fetchErr != nil {
+		if cached != nil && cached.servableStaleIfError() {
+			es.writeCached(w, cached, tier, "STALE")
+			es.trackAnalytics(r, "STALE-IF-ERROR", time.Since(start), len(cached.Content), geo)
+			log.Printf("[%s] Origin error for %s, serving stale-if-error copy: %v", es.ID, r.URL.Path, fetchErr)
+			return
+		}
+		log.Printf("[%s] Error fetching from origin: %v", es.ID, fetchErr)
 		http.Error(w, "Failed to fetch from origin", http.StatusBadGateway)
-		es.trackAnalytics(r, "ERROR", time.Since(start), 0)
+		es.trackAnalytics(r, "ERROR", time.Since(start), 0, geo)
 		return
 	}
+
+	// Clone the shared result so concurrent waiters don't race on the
+	// same header map while writing their own response.
+	result := v.(*originResult).clone()
+
+	// Only waiters count as coalesced: singleflight also reports the
+	// result as shared to the request that ran the fetch itself.
+	cacheStatus := "MISS"
+	if !leader {
+		cacheStatus = "MISS-COALESCED"
+		es.Metrics.OriginCoalesced.Inc()
+	}
+
+	for key, value := range result.headers {
+		w.Header().Set(key, value)
+	}
+	w.Header().Set("X-Cache", cacheStatus)
+	w.Header().Set("X-Cache-Tier", "MISS")
+	w.Header().Set("X-Edge-Server", es.ID)
+	w.Header().Set("X-Edge-Region", es.Region)
+	w.WriteHeader(result.statusCode)
+	w.Write(result.body)
+
+	es.trackAnalytics(r, cacheStatus, time.Since(start), len(result.body), geo)
+
+	log.Printf("[%s] Cache %s for %s - Status: %d, Size: %d bytes",
+		es.ID, cacheStatus, r.URL.Path, result.statusCode, len(result.body))
+}
+
+// writeCached writes a cached item to the client, tagging the response
+// with the given X-Cache status ("HIT" or "STALE").
+func (es *EdgeServer) writeCached(w http.ResponseWriter, item *CacheItem, tier, status string) {
+	for key, value := range item.Headers {
+		w.Header().Set(key, value)
+	}
+	w.Header().Set("X-Cache", status)
+	w.Header().Set("X-Cache-Tier", tier)
+	w.Header().Set("X-Edge-Server", es.ID)
+	w.Header().Set("X-Edge-Region", es.Region)
+	w.WriteHeader(item.StatusCode)
+	w.Write(item.Content)
+}
+
+// revalidate refreshes a stale cache entry in the background. It shares
+// es.originGroup with the synchronous fetch path so a revalidation
+// already in flight absorbs a concurrent cache miss for the same key
+// instead of firing a second origin request.
+func (es *EdgeServer) revalidate(r *http.Request, cacheKey, encoding string) {
+	_, err, _ := es.originGroup.Do(cacheKey, func() (interface{}, error) {
+		return es.fetchAndCacheOrigin(r, cacheKey, encoding)
+	})
+	if err != nil {
+		log.Printf("[%s] Background revalidation failed for %s: %v", es.ID, cacheKey, err)
+	}
+}
+
+// originResult is the shared, read-only outcome of a single origin
+// fetch. It is cloned before being written to any individual response so
+// that waiters on a coalesced singleflight call never mutate each
+// other's copy.
+type originResult struct {
+	statusCode int
+	headers    map[string]string
+	body       []byte
+}
+
+func (r *originResult) clone() *originResult {
+	headers := make(map[string]string, len(r.headers))
+	for k, v := range r.headers {
+		headers[k] = v
+	}
+	body := make([]byte, len(r.body))
+	copy(body, r.body)
+	return &originResult{statusCode: r.statusCode, headers: headers, body: body}
+}
+
+// fetchAndCacheOrigin fetches path from the origin, caches a successful
+// response, and returns the result to be shared across any requests that
+// were coalesced onto this fetch by es.originGroup. When the origin
+// hasn't already compressed the body itself, the body is compressed to
+// the negotiated encoding ("gzip", "br", or "" for identity) before being
+// cached, so the compressed variant is what subsequent matching requests
+// get served from cache.
+func (es *EdgeServer) fetchAndCacheOrigin(r *http.Request, cacheKey, encoding string) (*originResult, error) {
+	originResp, err := es.fetchFromOrigin(r)
+	if err != nil {
+		return nil, err
+	}
 	defer originResp.Body.Close()
 
 	es.Metrics.OriginRequests.Inc()
 
-	// Read response body
 	body, err := io.ReadAll(originResp.Body)
 	if err != nil {
-		log.Printf("[%s] Error reading origin response: %v", es.ID, err)
-		http.Error(w, "Failed to read origin response", http.StatusInternalServerError)
-		es.trackAnalytics(r, "ERROR", time.Since(start), 0)
-		return
+		return nil, fmt.Errorf("failed to read origin response: %v", err)
 	}
 
-	// Prepare cache item with headers
 	headers := make(map[string]string)
 	for key, values := range originResp.Header {
 		if len(values) > 0 {
 			headers[key] = values[0]
 		}
 	}
+	headers["Vary"] = "Accept-Encoding"
+
+	if encoding != "" && headers["Content-Encoding"] == "" && isCompressibleContentType(originResp.Header.Get("Content-Type")) {
+		compressed, err := compressBody(encoding, body)
+		if err != nil {
+			log.Printf("[%s] Failed to %s-compress response for %s: %v", es.ID, encoding, r.URL.Path, err)
+		} else {
+			body = compressed
+			headers["Content-Encoding"] = encoding
+			headers["Content-Length"] = strconv.Itoa(len(body))
+		}
+	}
+
+	noStore, ttl, swr, sie := es.determineCaching(originResp.Header, r.URL.Path)
 
-	// Create cache item
 	cacheItem := &CacheItem{
 		Content:    body,
 		Headers:    headers,
 		StatusCode: originResp.StatusCode,
 		Timestamp:  time.Now().Unix(),
-		TTL:        es.determineTTL(originResp.Header.Get("Content-Type"), r.URL.Path),
+		TTL:        ttl,
+		SWR:        swr,
+		SIE:        sie,
 	}
 
-	// Cache the response if status is successful
-	if originResp.StatusCode >= 200 && originResp.StatusCode < 400 {
-		if err := es.Cache.Set(cacheKey, cacheItem, time.Duration(cacheItem.TTL)*time.Second); err != nil {
+	storeFor := ttl + swr + sie
+	if !noStore && storeFor > 0 && originResp.StatusCode >= 200 && originResp.StatusCode < 400 {
+		if err := es.Cache.Set(r.Context(), cacheKey, cacheItem, time.Duration(storeFor)*time.Second); err != nil {
 			log.Printf("[%s] Failed to cache response: %v", es.ID, err)
 		}
 	}
 
-	// Send response to client
-	for key, value := range headers {
-		w.Header().Set(key, value)
+	return &originResult{statusCode: originResp.StatusCode, headers: headers, body: body}, nil
+}
+
+// determineCaching derives the TTL, stale-while-revalidate window, and
+// stale-if-error window for a response, preferring the origin's
+// Cache-Control/Expires directives and falling back to the content-type
+// based defaults when the origin gives no freshness information.
+func (es *EdgeServer) determineCaching(header http.Header, path string) (noStore bool, ttl, swr, sie int64) {
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	if directives.noStore {
+		return true, 0, 0, 0
 	}
-	w.Header().Set("X-Cache", "MISS")
-	w.Header().Set("X-Edge-Server", es.ID)
-	w.Header().Set("X-Edge-Region", es.Region)
-	w.WriteHeader(originResp.StatusCode)
-	w.Write(body)
 
-	// Track cache MISS analytics
-	es.trackAnalytics(r, "MISS", time.Since(start), len(body))
+	ttl, ok := directives.ttl, directives.ttlSet
+	if !ok {
+		if expires := header.Get("Expires"); expires != "" {
+			if t, err := http.ParseTime(expires); err == nil {
+				if secs := int64(time.Until(t).Seconds()); secs > 0 {
+					ttl, ok = secs, true
+				}
+			}
+		}
+	}
+	if !ok {
+		ttl = es.defaultTTL(header.Get("Content-Type"), path)
+	}
 
-	log.Printf("[%s] Cache MISS for %s - Status: %d, Size: %d bytes",
-		es.ID, r.URL.Path, originResp.StatusCode, len(body))
+	return false, ttl, directives.swr, directives.sie
 }
 
-// Helper function to determine TTL based on content type and path
-func (es *EdgeServer) determineTTL(contentType, path string) int64 {
+// defaultTTL is the content-type based fallback used when the origin
+// gives no Cache-Control/Expires freshness information.
+func (es *EdgeServer) defaultTTL(contentType, path string) int64 {
 	// Default TTL values in seconds
 	switch {
 	case strings.Contains(contentType, "image/"):
@@ -296,8 +581,11 @@ func (es *EdgeServer) fetchFromOrigin(r *http.Request) (*http.Response, error) {
 		Timeout: 30 * time.Second,
 	}
 
-	// Create new request to origin
-	originReq, err := http.NewRequest(r.Method, es.OriginURL+r.URL.Path, r.Body)
+	// Create new request to origin, deriving its context from the inbound
+	// request so that cancelling r.Context() (client disconnect, or the
+	// shutdown context it's ultimately rooted in) aborts this fetch
+	// promptly instead of holding a connection open past shutdown.
+	originReq, err := http.NewRequestWithContext(r.Context(), r.Method, es.OriginURL+r.URL.Path, r.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create origin request: %v", err)
 	}
@@ -317,36 +605,19 @@ func (es *EdgeServer) fetchFromOrigin(r *http.Request) (*http.Response, error) {
 	return client.Do(originReq)
 }
 
-func (c *Cache) Get(key string) (*CacheItem, error) {
-	data, err := c.redis.Get(c.ctx, key).Result()
-	if err != nil {
-		return nil, err
-	}
-
-	var item CacheItem
-	if err := json.Unmarshal([]byte(data), &item); err != nil {
-		return nil, err
-	}
-
-	// Check if expired
-	if time.Now().Unix() > item.Timestamp+item.TTL {
-		c.redis.Del(c.ctx, key)
-		return nil, redis.Nil
-	}
-
-	return &item, nil
-}
-
-func (c *Cache) Set(key string, item *CacheItem, ttl time.Duration) error {
-	data, err := json.Marshal(item)
-	if err != nil {
-		return err
+func (es *EdgeServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if es.shuttingDown.Load() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "shutting_down",
+			"edge_id":   es.ID,
+			"region":    es.Region,
+			"timestamp": time.Now().Unix(),
+		})
+		return
 	}
 
-	return c.redis.Set(c.ctx, key, data, ttl).Err()
-}
-
-func (es *EdgeServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	status := map[string]interface{}{
 		"status":    "healthy",
 		"edge_id":   es.ID,
@@ -354,13 +625,12 @@ func (es *EdgeServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 		"timestamp": time.Now().Unix(),
 	}
 
-	// Check Redis connectivity
-	_, err := es.RedisClient.Ping(es.Cache.ctx).Result()
-	if err != nil {
-		status["redis"] = "unhealthy"
+	// Check cache connectivity
+	if hc, ok := es.Cache.(HealthChecker); ok && !hc.Healthy() {
+		status["cache"] = "unhealthy"
 		status["status"] = "degraded"
 	} else {
-		status["redis"] = "healthy"
+		status["cache"] = "healthy"
 	}
 
 	// Check origin server connectivity
@@ -416,11 +686,20 @@ func (es *EdgeServer) loggingMiddleware(next http.Handler) http.Handler {
 func main() {
 	server := NewEdgeServer()
 
+	// serverCtx is canceled on SIGINT/SIGTERM and is the root of every
+	// in-flight request's context (via BaseContext below), so shutdown
+	// promptly cancels outstanding origin fetches instead of waiting out
+	// their full timeout.
+	serverCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	server.shutdownCtx = serverCtx
+
 	r := mux.NewRouter()
 
 	// Apply middleware
 	r.Use(server.corsMiddleware)
 	r.Use(server.loggingMiddleware)
+	r.Use(server.rateLimitMiddleware)
 
 	// Routes
 	r.HandleFunc("/health", server.handleHealth).Methods("GET")
@@ -432,7 +711,11 @@ func main() {
 	port := getEnv("PORT", "8080")
 	log.Printf("Edge server %s starting on port %s in region %s", server.ID, port, server.Region)
 	log.Printf("Origin URL: %s", server.OriginURL)
-	log.Printf("Redis URL: %s", getEnv("REDIS_URL", "redis://localhost:6379"))
+	if cacheURL := getEnv("CACHE_URL", ""); cacheURL != "" {
+		log.Printf("Cache URL: %s", cacheURL)
+	} else {
+		log.Printf("Redis URL: %s", getEnv("REDIS_URL", "redis://localhost:6379"))
+	}
 
 	srv := &http.Server{
 		Handler:      r,
@@ -440,9 +723,42 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 		ReadTimeout:  30 * time.Second,
 		IdleTimeout:  120 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return serverCtx },
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Edge server %s failed: %v", server.ID, err)
+		}
+	}()
+
+	<-serverCtx.Done()
+	server.shuttingDown.Store(true)
+	log.Printf("[%s] Shutdown signal received, draining in-flight requests", server.ID)
+
+	shutdownTimeout := time.Duration(getEnvInt("SHUTDOWN_TIMEOUT", 30)) * time.Second
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer drainCancel()
+
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("[%s] Error during shutdown: %v", server.ID, err)
+	}
+
+	server.analytics.Close()
+
+	if err := server.Cache.Close(); err != nil {
+		log.Printf("[%s] Error closing cache provider: %v", server.ID, err)
+	}
+	if server.RateLimiter != nil {
+		if err := server.RateLimiter.Close(); err != nil {
+			log.Printf("[%s] Error closing rate limiter: %v", server.ID, err)
+		}
+	}
+	if err := server.GeoIP.Close(); err != nil {
+		log.Printf("[%s] Error closing GeoIP database: %v", server.ID, err)
+	}
+
+	log.Printf("[%s] Shutdown complete", server.ID)
 }
 
 func getEnv(key, defaultValue string) string {
@@ -451,3 +767,16 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s=%q, using default %d: %v", key, value, defaultValue, err)
+		return defaultValue
+	}
+	return parsed
+}
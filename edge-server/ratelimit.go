@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	lru "github.com/hashicorp/golang-lru/v2/expirable"
+	"golang.org/x/time/rate"
+)
+
+// limiterCacheTTL bounds how long an idle per-IP token bucket is kept
+// around; a client that hasn't been seen in this long gets a fresh bucket
+// on its next request rather than the map growing without bound.
+const limiterCacheTTL = 10 * time.Minute
+
+// RateLimiter enforces a per-client-IP token-bucket limit locally, and
+// optionally a distributed limit shared across edges via Redis INCR +
+// EXPIRE over fixed one-second windows. A nil *RateLimiter disables
+// rate limiting entirely.
+type RateLimiter struct {
+	rps   rate.Limit
+	burst int
+	local *lru.LRU[string, *rate.Limiter]
+
+	// globalBurst is the fleet-wide cap enforced by allowGlobal, separate
+	// from the local per-edge burst above - it must scale with the
+	// expected aggregate rate across all edges, not one edge's own limit.
+	globalBurst int
+
+	redis *redis.Client
+	ctx   context.Context
+}
+
+// NewRateLimiter builds a RateLimiter from RATE_RPS/RATE_BURST. When
+// globalRedisURL is non-empty, a second tier keyed by globalBurst (from
+// RATE_GLOBAL_RPS/RATE_GLOBAL_BURST) is enforced across all edges sharing
+// that Redis instance.
+func NewRateLimiter(rps float64, burst int, globalBurst int, globalRedisURL string) (*RateLimiter, error) {
+	rl := &RateLimiter{
+		rps:         rate.Limit(rps),
+		burst:       burst,
+		globalBurst: globalBurst,
+		local:       lru.NewLRU[string, *rate.Limiter](10000, nil, limiterCacheTTL),
+		ctx:         context.Background(),
+	}
+
+	if globalRedisURL == "" {
+		return rl, nil
+	}
+
+	opt, err := redis.ParseURL(globalRedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rate limit Redis URL: %w", err)
+	}
+	rl.redis = redis.NewClient(opt)
+	if _, err := rl.redis.Ping(rl.ctx).Result(); err != nil {
+		return nil, fmt.Errorf("failed to connect to rate limit Redis: %w", err)
+	}
+
+	return rl, nil
+}
+
+// allowLocal reports whether the request from key is within the local
+// token-bucket limit, lazily creating a limiter for keys not seen before.
+func (rl *RateLimiter) allowLocal(key string) bool {
+	limiter, ok := rl.local.Get(key)
+	if !ok {
+		limiter = rate.NewLimiter(rl.rps, rl.burst)
+	}
+	// Add on every request restarts the entry's expiry, so only buckets
+	// that have been idle for limiterCacheTTL age out.
+	rl.local.Add(key, limiter)
+	return limiter.Allow()
+}
+
+// allowGlobal reports whether key is within the distributed limit for the
+// current one-second window. It fails open (allowed) on Redis errors so a
+// flaky global tier never blocks traffic the local tier already approved.
+func (rl *RateLimiter) allowGlobal(key string) bool {
+	if rl.redis == nil {
+		return true
+	}
+
+	window := time.Now().Unix()
+	redisKey := fmt.Sprintf("ratelimit:%s:%d", key, window)
+
+	count, err := rl.redis.Incr(rl.ctx, redisKey).Result()
+	if err != nil {
+		return true
+	}
+	if count == 1 {
+		rl.redis.Expire(rl.ctx, redisKey, 2*time.Second)
+	}
+
+	return count <= int64(rl.globalBurst)
+}
+
+func (rl *RateLimiter) Close() error {
+	if rl.redis == nil {
+		return nil
+	}
+	return rl.redis.Close()
+}
+
+// rateLimitMiddleware applies es.RateLimiter to every request, keyed by
+// client IP (respecting X-Forwarded-For via the same trusted-proxy rules
+// used for GeoIP). A nil RateLimiter is a no-op, so rate limiting can be
+// left disabled by leaving RATE_RPS unset.
+func (es *EdgeServer) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if es.RateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := clientIPForGeo(r, es.trustedProxies).String()
+
+		if !es.RateLimiter.allowLocal(key) {
+			es.Metrics.RateLimited.WithLabelValues("local").Inc()
+			writeRateLimited(w, es.RateLimiter.rps)
+			return
+		}
+
+		if !es.RateLimiter.allowGlobal(key) {
+			es.Metrics.RateLimited.WithLabelValues("global").Inc()
+			writeRateLimited(w, es.RateLimiter.rps)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeRateLimited writes a 429 response with Retry-After and
+// X-RateLimit-* headers for a client that has exceeded rps.
+func writeRateLimited(w http.ResponseWriter, rps rate.Limit) {
+	retryAfter := 1
+	if rps > 0 {
+		retryAfter = int(1 / float64(rps))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("X-RateLimit-Remaining", "0")
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(retryAfter))
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// analyticsQueueSize bounds how many pending analytics events can queue up
+// behind a slow or unreachable analytics service before new events are
+// dropped rather than piling up unboundedly in memory.
+const analyticsQueueSize = 1000
+
+// analyticsPool is a bounded worker pool that sends analytics events in
+// the background. Unlike a bare `go func() {...}()` per event, shutdown
+// can wait on it via Close so in-flight POSTs aren't abandoned mid-request
+// when the process exits.
+type analyticsPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+
+	// mu guards closed so submit and Close can never race: net/http's
+	// Shutdown only waits best-effort for in-flight handlers, so a
+	// lingering handler can still call submit after Close has begun.
+	// Without this guard that submit could send on (or just after)
+	// a closed jobs channel and panic.
+	mu     sync.Mutex
+	closed bool
+}
+
+// newAnalyticsPool starts workers goroutines draining a shared job queue.
+func newAnalyticsPool(workers int) *analyticsPool {
+	p := &analyticsPool{jobs: make(chan func(), analyticsQueueSize)}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+
+	return p
+}
+
+// submit queues job for a worker to run. If the queue is full, or the
+// pool has already been closed, job is dropped and logged rather than
+// blocking the request path or sending on a closed channel.
+func (p *analyticsPool) submit(job func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		log.Println("analytics pool closed, dropping event")
+		return
+	}
+
+	select {
+	case p.jobs <- job:
+	default:
+		log.Println("analytics queue full, dropping event")
+	}
+}
+
+// Close stops accepting new jobs and blocks until every queued job has
+// been run by a worker.
+func (p *analyticsPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	close(p.jobs)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}